@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// adminDrainTimeout bounds how long POST /admin/backends/{url}/drain waits
+// for a backend's in-flight requests to reach zero before reporting failure.
+const adminDrainTimeout = 30 * time.Second
+
+// backendStatus is the JSON shape returned by GET /admin/backends.
+type backendStatus struct {
+	URL         string    `json:"url"`
+	Healthy     bool      `json:"healthy"`
+	Drained     bool      `json:"drained"`
+	Weight      int       `json:"weight"`
+	InFlight    int64     `json:"in_flight"`
+	LastProbeAt time.Time `json:"last_probe_at"`
+}
+
+// newAdminMux builds the admin HTTP API bound to Config.AdminPort: backend
+// status plus runtime add/remove/drain and a config reload trigger, all
+// operating on lb and lc, the same registry and live Config the frontend
+// handler reads from.
+func newAdminMux(lb *LoadBalancer, lc *liveConfig) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/backends", handleAdminBackendsCollection(lb))
+	mux.HandleFunc("/admin/backends/", handleAdminBackendsItem(lb))
+	mux.HandleFunc("/admin/reload", handleAdminReload(lb, lc))
+	return mux
+}
+
+// handleAdminBackendsCollection serves GET /admin/backends (status listing)
+// and POST /admin/backends (register a new backend, body {"url": "..."}).
+func handleAdminBackendsCollection(lb *LoadBalancer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, listBackendStatuses(lb))
+		case http.MethodPost:
+			var body struct {
+				URL string `json:"url"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.URL == "" {
+				http.Error(w, `expected a JSON body with a non-empty "url"`, http.StatusBadRequest)
+				return
+			}
+			lb.Add(body.URL)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// handleAdminBackendsItem serves DELETE /admin/backends/{url} (unregister)
+// and POST /admin/backends/{url}/drain (take it out of rotation and wait for
+// its in-flight count to reach zero; see LoadBalancer.Drain for which
+// strategies keep that count). {url} is the backend's URL, base64url-encoded
+// (encoding/base64's RawURLEncoding) so its own "://" doesn't collide with
+// path segment boundaries or trigger ServeMux's unclean-path redirects.
+func handleAdminBackendsItem(lb *LoadBalancer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.TrimPrefix(r.URL.Path, "/admin/backends/")
+		encodedURL, action, hasAction := strings.Cut(rest, "/")
+
+		decoded, err := base64.RawURLEncoding.DecodeString(encodedURL)
+		if err != nil || len(decoded) == 0 {
+			http.Error(w, "invalid backend URL", http.StatusBadRequest)
+			return
+		}
+		backendURL := string(decoded)
+
+		switch {
+		case hasAction && action == "drain" && r.Method == http.MethodPost:
+			if lb.Drain(backendURL, adminDrainTimeout) {
+				w.WriteHeader(http.StatusOK)
+			} else {
+				http.Error(w, "backend did not drain within the timeout", http.StatusGatewayTimeout)
+			}
+		case !hasAction && r.Method == http.MethodDelete:
+			if lb.Remove(backendURL) {
+				w.WriteHeader(http.StatusOK)
+			} else {
+				http.Error(w, "unknown backend", http.StatusNotFound)
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// handleAdminReload re-reads config.json, registers any backends it lists
+// that aren't already known, and probes them immediately. It also swaps in
+// the reloaded file's Strategy, CircuitBreaker, and Retry settings via lc,
+// so frontendHandler picks them up on its next request. It never removes
+// backends missing from the reloaded file, nor ones added at runtime
+// through POST /admin/backends.
+func handleAdminReload(lb *LoadBalancer, lc *liveConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		reloaded, err := loadConfigFile("config.json")
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+			return
+		}
+
+		lb.Sync(reloaded.BackendURLs)
+		for backendURL, weight := range reloaded.BackendWeights {
+			setBackendWeight(backendURL, weight)
+		}
+		probeAll(lb, reloaded)
+		lc.store(lb, reloaded)
+
+		writeJSON(w, http.StatusOK, listBackendStatuses(lb))
+	}
+}
+
+// listBackendStatuses snapshots every backend registered with lb for the
+// admin API.
+func listBackendStatuses(lb *LoadBalancer) []backendStatus {
+	backends := lb.All()
+	statuses := make([]backendStatus, 0, len(backends))
+	for _, backendURL := range backends {
+		snap := getOrCreateBackendState(backendURL).snapshot()
+		statuses = append(statuses, backendStatus{
+			URL:         backendURL,
+			Healthy:     snap.Healthy,
+			Drained:     lb.IsDrained(backendURL),
+			Weight:      snap.Weight,
+			InFlight:    snap.InFlight,
+			LastProbeAt: snap.LastProbeAt,
+		})
+	}
+	return statuses
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("WARN: failed to encode admin response: %s\n", err)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// TestAdminAddBackendReceivesTrafficAfterProbe verifies that a backend
+// registered at runtime through POST /admin/backends is picked up by the
+// next health probe and subsequently receives requests.
+func TestAdminAddBackendReceivesTrafficAfterProbe(t *testing.T) {
+	existing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer existing.Close()
+
+	var newBackendHits int
+	newBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		newBackendHits++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer newBackend.Close()
+
+	lb := NewLoadBalancer([]string{existing.URL})
+	setBackendHealth(existing.URL, true)
+
+	config := Config{BackendURLs: []string{existing.URL}}
+	lc := newLiveConfig(lb, config)
+
+	admin := httptest.NewServer(newAdminMux(lb, lc))
+	defer admin.Close()
+
+	body, _ := json.Marshal(map[string]string{"url": newBackend.URL})
+	resp, err := http.Post(admin.URL+"/admin/backends", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /admin/backends returned %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+
+	// Not yet eligible for picks until a probe confirms it's healthy.
+	probeAll(lb, config)
+	if !isBackendHealthy(newBackend.URL) {
+		t.Fatal("expected the newly added backend to be healthy after a probe pass")
+	}
+
+	for i := 0; i < 10 && newBackendHits == 0; i++ {
+		req, err := http.NewRequest("GET", "http://localhost", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = "192.168.1.1:12345"
+
+		rr := httptest.NewRecorder()
+		frontendHandler(lb, lc)(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("request %d: unexpected status %d", i, rr.Code)
+		}
+	}
+
+	if newBackendHits == 0 {
+		t.Error("expected the newly added backend to eventually receive a request")
+	}
+}
+
+// TestAdminRemoveBackendStaysRemovedAcrossProbes verifies that a backend
+// unregistered through DELETE /admin/backends/{url} does not get silently
+// re-added by the next health-check pass, even though it's still listed in
+// the original startup Config.
+func TestAdminRemoveBackendStaysRemovedAcrossProbes(t *testing.T) {
+	backendA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backendA.Close()
+
+	backendB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backendB.Close()
+
+	lb := NewLoadBalancer([]string{backendA.URL, backendB.URL})
+	setBackendHealth(backendA.URL, true)
+	setBackendHealth(backendB.URL, true)
+
+	config := Config{BackendURLs: []string{backendA.URL, backendB.URL}}
+
+	admin := httptest.NewServer(newAdminMux(lb, newLiveConfig(lb, config)))
+	defer admin.Close()
+
+	deletePath := "/admin/backends/" + base64.RawURLEncoding.EncodeToString([]byte(backendB.URL))
+	req, err := http.NewRequest(http.MethodDelete, admin.URL+deletePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("DELETE %s returned %d, want %d", deletePath, resp.StatusCode, http.StatusOK)
+	}
+
+	// This is exactly what the next health-check tick does; it must not
+	// bring the removed backend back.
+	probeAll(lb, config)
+
+	for _, backendURL := range lb.All() {
+		if backendURL == backendB.URL {
+			t.Fatal("expected removed backend to stay removed after a health-check pass, but it reappeared")
+		}
+	}
+}
+
+// TestAdminDrainStopsDispatchingToBackend verifies that after
+// POST /admin/backends/{url}/drain completes, no further requests are
+// dispatched to that backend even though it stays registered and healthy.
+func TestAdminDrainStopsDispatchingToBackend(t *testing.T) {
+	backendA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backendA.Close()
+
+	var backendBHits int
+	backendB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendBHits++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backendB.Close()
+
+	lb := NewLoadBalancer([]string{backendA.URL, backendB.URL})
+	setBackendHealth(backendA.URL, true)
+	setBackendHealth(backendB.URL, true)
+
+	config := Config{BackendURLs: []string{backendA.URL, backendB.URL}}
+	lc := newLiveConfig(lb, config)
+
+	admin := httptest.NewServer(newAdminMux(lb, lc))
+	defer admin.Close()
+
+	drainPath := "/admin/backends/" + base64.RawURLEncoding.EncodeToString([]byte(backendB.URL)) + "/drain"
+	resp, err := http.Post(admin.URL+drainPath, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST %s returned %d, want %d", drainPath, resp.StatusCode, http.StatusOK)
+	}
+
+	if !lb.IsDrained(backendB.URL) {
+		t.Fatal("expected backend B to be marked drained")
+	}
+
+	for i := 0; i < 20; i++ {
+		req, err := http.NewRequest("GET", "http://localhost", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = "192.168.1.1:12345"
+
+		rr := httptest.NewRecorder()
+		frontendHandler(lb, lc)(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("request %d: unexpected status %d", i, rr.Code)
+		}
+	}
+
+	if backendBHits != 0 {
+		t.Errorf("expected drained backend B to receive no requests, got %d", backendBHits)
+	}
+}
+
+// TestAdminBackendsResponseAlwaysIncludesLastProbeAt verifies that
+// GET /admin/backends reports last_probe_at for a backend that hasn't been
+// probed yet, rather than silently dropping the field: encoding/json's
+// omitempty has no effect on a non-pointer time.Time, so it must not be used
+// on that field or the key would misleadingly appear to be optional.
+func TestAdminBackendsResponseAlwaysIncludesLastProbeAt(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).URL
+
+	lb := NewLoadBalancer([]string{backend})
+	config := Config{BackendURLs: []string{backend}}
+
+	admin := httptest.NewServer(newAdminMux(lb, newLiveConfig(lb, config)))
+	defer admin.Close()
+
+	resp, err := http.Get(admin.URL + "/admin/backends")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var raw []map[string]json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 1 {
+		t.Fatalf("expected 1 backend in the response, got %d", len(raw))
+	}
+	if _, ok := raw[0]["last_probe_at"]; !ok {
+		t.Error("expected last_probe_at to be present even for a never-probed backend")
+	}
+}
+
+// TestAdminReloadAppliesStrategyCircuitBreakerAndRetry verifies that
+// POST /admin/reload swaps in a changed Strategy, CircuitBreaker, and Retry
+// from config.json, not just backend membership and weights: before this
+// fix, those three settings were only ever read once at StartServer time and
+// a reload silently kept the stale ones in effect.
+func TestAdminReloadAppliesStrategyCircuitBreakerAndRetry(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})).URL
+
+	config := Config{
+		BackendURLs: []string{backend},
+		Strategy:    "round_robin",
+		Retry:       RetryConfig{MaxAttempts: 1},
+	}
+
+	lb := NewLoadBalancer(config.BackendURLs)
+	lc := newLiveConfig(lb, config)
+
+	admin := httptest.NewServer(newAdminMux(lb, lc))
+	defer admin.Close()
+
+	reloaded := config
+	reloaded.Strategy = "ip_hash"
+	reloaded.Retry = RetryConfig{MaxAttempts: 3}
+	reloaded.CircuitBreaker = CircuitBreakerConfig{FailureThreshold: 0.9, MinSamples: 20}
+	raw, err := json.Marshal(reloaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("config.json", raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("config.json")
+
+	resp, err := http.Post(admin.URL+"/admin/reload", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /admin/reload returned %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	gotConfig, gotBalancer := lc.load()
+	if gotConfig.Retry.MaxAttempts != 3 {
+		t.Errorf("Retry.MaxAttempts = %d, want 3", gotConfig.Retry.MaxAttempts)
+	}
+	if gotConfig.CircuitBreaker != reloaded.CircuitBreaker {
+		t.Errorf("CircuitBreaker = %+v, want %+v", gotConfig.CircuitBreaker, reloaded.CircuitBreaker)
+	}
+	if _, ok := gotBalancer.(*ipHashBalancer); !ok {
+		t.Errorf("balancer = %T, want *ipHashBalancer now that Strategy was reloaded to %q", gotBalancer, reloaded.Strategy)
+	}
+}
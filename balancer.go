@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errNoHealthyBackend is returned by a Balancer when no configured backend
+// is currently healthy.
+var errNoHealthyBackend = errors.New("no healthy backend available")
+
+// excludedBackendsKey is the context key frontendHandler uses to tell a
+// Balancer which backends this request's retry loop has already tried, so a
+// sticky-session strategy like ip_hash (or a tie in least_conn) doesn't just
+// hand the retry straight back to the backend that failed it.
+type excludedBackendsKey struct{}
+
+// withExcludedBackend returns a shallow copy of r whose context records
+// backendURL as already attempted for this request.
+func withExcludedBackend(r *http.Request, backendURL string) *http.Request {
+	next := make(map[string]bool, len(excludedBackendsFrom(r))+1)
+	for url := range excludedBackendsFrom(r) {
+		next[url] = true
+	}
+	next[backendURL] = true
+	return r.WithContext(context.WithValue(r.Context(), excludedBackendsKey{}, next))
+}
+
+// excludedBackendsFrom returns the backend URLs already attempted for r's
+// retry loop, or nil if this is the first attempt.
+func excludedBackendsFrom(r *http.Request) map[string]bool {
+	excluded, _ := r.Context().Value(excludedBackendsKey{}).(map[string]bool)
+	return excluded
+}
+
+// withoutExcluded filters excluded backend URLs out of backendURLs,
+// preserving order. If every backend would be filtered out, it returns
+// backendURLs unchanged so a Balancer still has somewhere to retry once
+// it's genuinely out of untried options.
+func withoutExcluded(backendURLs []string, excluded map[string]bool) []string {
+	if len(excluded) == 0 {
+		return backendURLs
+	}
+	remaining := make([]string, 0, len(backendURLs))
+	for _, backendURL := range backendURLs {
+		if !excluded[backendURL] {
+			remaining = append(remaining, backendURL)
+		}
+	}
+	if len(remaining) == 0 {
+		return backendURLs
+	}
+	return remaining
+}
+
+// backendState holds the mutable per-backend bookkeeping shared by health
+// checks and load-balancing strategies. weight is fixed once at startup and
+// needs no locking; inFlight is updated with sync/atomic so strategies can
+// read it without taking a lock; healthy/observed are guarded by mu since
+// health checks and strategy picks run concurrently.
+type backendState struct {
+	weight int
+
+	mu          sync.RWMutex
+	healthy     bool
+	observed    bool
+	lastProbeAt time.Time
+
+	inFlight int64
+
+	// currentWeight is roundRobinBalancer's smooth-weighted-round-robin
+	// bookkeeping. It is only ever touched while roundRobinMu is held, so it
+	// needs no additional synchronization here.
+	currentWeight int
+}
+
+func (b *backendState) isHealthy() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.healthy
+}
+
+// backendSnapshot is a point-in-time, lock-free copy of a backend's
+// bookkeeping, suitable for the admin API to serialize.
+type backendSnapshot struct {
+	Healthy     bool      `json:"healthy"`
+	Observed    bool      `json:"observed"`
+	LastProbeAt time.Time `json:"last_probe_at"`
+	Weight      int       `json:"weight"`
+	InFlight    int64     `json:"in_flight"`
+}
+
+func (b *backendState) snapshot() backendSnapshot {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return backendSnapshot{
+		Healthy:     b.healthy,
+		Observed:    b.observed,
+		LastProbeAt: b.lastProbeAt,
+		Weight:      b.weight,
+		InFlight:    atomic.LoadInt64(&b.inFlight),
+	}
+}
+
+// isBackendHealthy reports the current health status of backendURL.
+func isBackendHealthy(backendURL string) bool {
+	return getOrCreateBackendState(backendURL).isHealthy()
+}
+
+var (
+	backendsMu    sync.Mutex
+	backendStates = make(map[string]*backendState)
+)
+
+// getOrCreateBackendState returns the shared state for backendURL, creating
+// it with a default weight of 1 on first use.
+func getOrCreateBackendState(backendURL string) *backendState {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	state, ok := backendStates[backendURL]
+	if !ok {
+		state = &backendState{weight: 1}
+		backendStates[backendURL] = state
+	}
+	return state
+}
+
+// setBackendWeight overrides the default weight of 1 for backendURL.
+func setBackendWeight(backendURL string, weight int) {
+	if weight <= 0 {
+		return
+	}
+	getOrCreateBackendState(backendURL).weight = weight
+}
+
+// noopRelease is returned by strategies that don't track in-flight requests.
+func noopRelease() {}
+
+// Balancer selects which backend should handle a given request.
+type Balancer interface {
+	// Pick returns the chosen backend's base URL and a release function that
+	// must be called once the request has finished (a no-op for strategies
+	// that don't track in-flight counts), or errNoHealthyBackend if nothing
+	// is currently healthy. If r's context carries backends already tried
+	// this request (see withExcludedBackend), a strategy should steer away
+	// from them when it can.
+	Pick(r *http.Request) (backend string, release func(), err error)
+}
+
+// newBalancer constructs the Balancer selected by config.Strategy, applying
+// any per-backend weights from config.BackendWeights first. Strategies read
+// the live backend set from lb on every Pick rather than a snapshot taken
+// here, so backends added, removed, or drained at runtime take effect
+// immediately. It defaults to weighted round-robin when Strategy is unset or
+// unrecognized.
+func newBalancer(lb *LoadBalancer, config Config) Balancer {
+	lb.Sync(config.BackendURLs)
+	for backendURL, weight := range config.BackendWeights {
+		setBackendWeight(backendURL, weight)
+	}
+
+	switch config.Strategy {
+	case "", "round_robin":
+		return &roundRobinBalancer{lb: lb, cbConfig: config.CircuitBreaker}
+	case "least_conn":
+		return &leastConnBalancer{lb: lb, cbConfig: config.CircuitBreaker}
+	case "ip_hash":
+		return &ipHashBalancer{lb: lb, cbConfig: config.CircuitBreaker}
+	case "p2c":
+		return &p2cBalancer{lb: lb, cbConfig: config.CircuitBreaker, rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	default:
+		log.Printf("WARN: unknown load balancing strategy %q, falling back to round_robin\n", config.Strategy)
+		return &roundRobinBalancer{lb: lb, cbConfig: config.CircuitBreaker}
+	}
+}
+
+// roundRobinBalancer distributes requests across eligible backends using
+// Nginx-style smooth weighted round-robin: each pick favors whichever
+// eligible backend has drifted furthest ahead of its configured weight
+// share. A backend is eligible when it is passing health checks and its
+// circuit breaker isn't tripped open.
+type roundRobinBalancer struct {
+	lb       *LoadBalancer
+	cbConfig CircuitBreakerConfig
+}
+
+// roundRobinMu guards every backendState's currentWeight. It is shared by all
+// roundRobinBalancer instances, since a reload builds a new one while
+// requests holding the previous one may still be picking.
+var roundRobinMu sync.Mutex
+
+func (b *roundRobinBalancer) Pick(r *http.Request) (string, func(), error) {
+	roundRobinMu.Lock()
+	defer roundRobinMu.Unlock()
+
+	var selectedURL string
+	var selected *backendState
+	totalWeight := 0
+
+	for _, backendURL := range b.lb.Backends() {
+		if !backendEligible(backendURL, b.cbConfig) {
+			continue
+		}
+		state := getOrCreateBackendState(backendURL)
+
+		totalWeight += state.weight
+		state.currentWeight += state.weight
+		if selected == nil || state.currentWeight > selected.currentWeight {
+			selected = state
+			selectedURL = backendURL
+		}
+	}
+
+	if selected == nil {
+		return "", nil, errNoHealthyBackend
+	}
+
+	selected.currentWeight -= totalWeight
+	return selectedURL, noopRelease, nil
+}
+
+// leastConnBalancer routes each request to the eligible backend with the
+// fewest in-flight requests, as tracked by an atomic counter released when
+// the request completes. A backend already tried earlier in this request's
+// retry loop is excluded from consideration as long as some other eligible
+// backend remains, so a retry doesn't land back on a tied loser.
+type leastConnBalancer struct {
+	lb       *LoadBalancer
+	cbConfig CircuitBreakerConfig
+}
+
+func (b *leastConnBalancer) Pick(r *http.Request) (string, func(), error) {
+	var selectedURL string
+	var selected *backendState
+	var minInFlight int64
+
+	candidates := withoutExcluded(b.lb.Backends(), excludedBackendsFrom(r))
+	for _, backendURL := range candidates {
+		if !backendEligible(backendURL, b.cbConfig) {
+			continue
+		}
+		state := getOrCreateBackendState(backendURL)
+
+		inFlight := atomic.LoadInt64(&state.inFlight)
+		if selected == nil || inFlight < minInFlight {
+			selected = state
+			selectedURL = backendURL
+			minInFlight = inFlight
+		}
+	}
+
+	if selected == nil {
+		return "", nil, errNoHealthyBackend
+	}
+
+	atomic.AddInt64(&selected.inFlight, 1)
+	return selectedURL, func() { atomic.AddInt64(&selected.inFlight, -1) }, nil
+}
+
+// ipHashBalancer consistently maps a client's remote address onto one of the
+// eligible backends, giving the same client the same backend (sticky
+// sessions) for as long as the set of eligible backends stays the same. A
+// retry for the same request excludes whichever backend(s) already failed
+// it, so the sticky hash doesn't just send the retry straight back; once
+// every eligible backend has been tried, it falls back to the plain hash.
+type ipHashBalancer struct {
+	lb       *LoadBalancer
+	cbConfig CircuitBreakerConfig
+}
+
+func (b *ipHashBalancer) Pick(r *http.Request) (string, func(), error) {
+	eligible := eligibleBackendsFrom(b.lb.Backends(), b.cbConfig)
+	if len(eligible) == 0 {
+		return "", nil, errNoHealthyBackend
+	}
+	eligible = withoutExcluded(eligible, excludedBackendsFrom(r))
+
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		host = h
+	}
+
+	hasher := fnv.New32a()
+	hasher.Write([]byte(host))
+	index := hasher.Sum32() % uint32(len(eligible))
+
+	return eligible[index], noopRelease, nil
+}
+
+// p2cBalancer implements power-of-two-choices: it samples two eligible
+// backends at random and routes to whichever has fewer in-flight requests,
+// approximating least-connections without needing a global view on every
+// pick.
+type p2cBalancer struct {
+	lb       *LoadBalancer
+	cbConfig CircuitBreakerConfig
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func (b *p2cBalancer) Pick(r *http.Request) (string, func(), error) {
+	eligible := eligibleBackendsFrom(b.lb.Backends(), b.cbConfig)
+	if len(eligible) == 0 {
+		return "", nil, errNoHealthyBackend
+	}
+
+	chosenURL := eligible[0]
+	if len(eligible) > 1 {
+		b.mu.Lock()
+		i := b.rnd.Intn(len(eligible))
+		j := b.rnd.Intn(len(eligible) - 1)
+		b.mu.Unlock()
+		if j >= i {
+			j++
+		}
+
+		chosenURL = eligible[i]
+		first := getOrCreateBackendState(eligible[i])
+		second := getOrCreateBackendState(eligible[j])
+		if atomic.LoadInt64(&second.inFlight) < atomic.LoadInt64(&first.inFlight) {
+			chosenURL = eligible[j]
+		}
+	}
+
+	state := getOrCreateBackendState(chosenURL)
+	atomic.AddInt64(&state.inFlight, 1)
+	return chosenURL, func() { atomic.AddInt64(&state.inFlight, -1) }, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func newSeededRand() *rand.Rand {
+	return rand.New(rand.NewSource(1))
+}
+
+func newTestRequest(remoteAddr string) *http.Request {
+	req, err := http.NewRequest("GET", "http://localhost", nil)
+	if err != nil {
+		panic(err)
+	}
+	req.RemoteAddr = remoteAddr
+	return req
+}
+
+func TestRoundRobinBalancerWeightedDistribution(t *testing.T) {
+	backendA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).URL
+	backendB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).URL
+	setBackendHealth(backendA, true)
+	setBackendHealth(backendB, true)
+	setBackendWeight(backendA, 2)
+	setBackendWeight(backendB, 1)
+
+	balancer := &roundRobinBalancer{lb: NewLoadBalancer([]string{backendA, backendB})}
+
+	counts := map[string]int{}
+	const total = 30
+	for i := 0; i < total; i++ {
+		backend, release, err := balancer.Pick(newTestRequest("192.168.1.1:1234"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		release()
+		counts[backend]++
+	}
+
+	// A carries weight 2 against B's weight 1, so it should receive roughly
+	// twice as many picks.
+	if counts[backendA] <= counts[backendB] {
+		t.Errorf("expected backend A (weight 2) to receive more picks than backend B (weight 1): got A=%d B=%d", counts[backendA], counts[backendB])
+	}
+	if counts[backendA]+counts[backendB] != total {
+		t.Errorf("picks should always land on a healthy backend: got A=%d B=%d, want sum %d", counts[backendA], counts[backendB], total)
+	}
+}
+
+func TestLeastConnBalancerPrefersIdleBackend(t *testing.T) {
+	busy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).URL
+	idle := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).URL
+	setBackendHealth(busy, true)
+	setBackendHealth(idle, true)
+
+	balancer := &leastConnBalancer{lb: NewLoadBalancer([]string{busy, idle})}
+
+	// Simulate busy already handling 3 in-flight requests.
+	busyState := getOrCreateBackendState(busy)
+	busyState.inFlight = 3
+
+	backend, release, err := balancer.Pick(newTestRequest("192.168.1.1:1234"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer release()
+
+	if backend != idle {
+		t.Errorf("expected least_conn to prefer the idle backend, got %s", backend)
+	}
+	if got := getOrCreateBackendState(idle).inFlight; got != 1 {
+		t.Errorf("expected in-flight count to be incremented to 1, got %d", got)
+	}
+
+	release()
+	if got := getOrCreateBackendState(idle).inFlight; got != 0 {
+		t.Errorf("expected release to decrement in-flight count back to 0, got %d", got)
+	}
+}
+
+func TestLeastConnBalancerTieExcludesAlreadyTriedBackend(t *testing.T) {
+	backendA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).URL
+	backendB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).URL
+	setBackendHealth(backendA, true)
+	setBackendHealth(backendB, true)
+
+	balancer := &leastConnBalancer{lb: NewLoadBalancer([]string{backendA, backendB})}
+
+	req := withExcludedBackend(newTestRequest("192.168.1.1:1234"), backendA)
+	backend, release, err := balancer.Pick(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer release()
+
+	if backend != backendB {
+		t.Errorf("expected a tied pick to avoid the already-tried backend %s, got %s", backendA, backend)
+	}
+}
+
+func TestIPHashBalancerPickExcludesFailedBackendOnRetry(t *testing.T) {
+	backendA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).URL
+	backendB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).URL
+	setBackendHealth(backendA, true)
+	setBackendHealth(backendB, true)
+
+	balancer := &ipHashBalancer{lb: NewLoadBalancer([]string{backendA, backendB})}
+
+	first, _, err := balancer.Pick(newTestRequest("203.0.113.9:4321"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	other := backendA
+	if first == backendA {
+		other = backendB
+	}
+
+	retryReq := withExcludedBackend(newTestRequest("203.0.113.9:4321"), first)
+	retry, _, err := balancer.Pick(retryReq)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if retry != other {
+		t.Errorf("expected a retry excluding %s to land on %s, got %s", first, other, retry)
+	}
+}
+
+func TestIPHashBalancerIsSticky(t *testing.T) {
+	backendA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).URL
+	backendB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).URL
+	setBackendHealth(backendA, true)
+	setBackendHealth(backendB, true)
+
+	balancer := &ipHashBalancer{lb: NewLoadBalancer([]string{backendA, backendB})}
+
+	first, _, err := balancer.Pick(newTestRequest("203.0.113.9:4321"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		again, _, err := balancer.Pick(newTestRequest("203.0.113.9:4321"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if again != first {
+			t.Errorf("expected ip_hash to consistently route the same client to %s, got %s", first, again)
+		}
+	}
+}
+
+func TestP2CBalancerUsesBothBackends(t *testing.T) {
+	backendA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).URL
+	backendB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).URL
+	setBackendHealth(backendA, true)
+	setBackendHealth(backendB, true)
+
+	balancer := &p2cBalancer{lb: NewLoadBalancer([]string{backendA, backendB}), rnd: newSeededRand()}
+
+	counts := map[string]int{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	const total = 50
+	for i := 0; i < total; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			backend, release, err := balancer.Pick(newTestRequest("192.168.1.1:1234"))
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			defer release()
+
+			mu.Lock()
+			counts[backend]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if counts[backendA] == 0 || counts[backendB] == 0 {
+		t.Errorf("expected p2c to spread picks across both backends, got A=%d B=%d", counts[backendA], counts[backendB])
+	}
+	if counts[backendA]+counts[backendB] != total {
+		t.Errorf("expected every pick to land on a healthy backend: got A=%d B=%d, want sum %d", counts[backendA], counts[backendB], total)
+	}
+	if getOrCreateBackendState(backendA).inFlight != 0 || getOrCreateBackendState(backendB).inFlight != 0 {
+		t.Errorf("expected every release to bring in-flight counts back to 0")
+	}
+}
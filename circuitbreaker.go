@@ -0,0 +1,144 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// CircuitBreakerConfig configures the per-backend circuit breaker sitting
+// between the load balancer and the reverse proxy.
+type CircuitBreakerConfig struct {
+	FailureThreshold float64 `json:"failure_threshold,omitempty"` // ratio (0-1) of failed calls that trips the breaker; defaults to 0.5
+	MinSamples       int     `json:"min_samples,omitempty"`       // minimum calls observed before the ratio is evaluated; defaults to 5
+	OpenTimeout      string  `json:"open_timeout,omitempty"`      // cooldown before probing an open breaker again, e.g. "30s"; defaults to 30s
+}
+
+// Defaults applied when a CircuitBreakerConfig field is left unset.
+const (
+	defaultCircuitBreakerFailureThreshold = 0.5
+	defaultCircuitBreakerMinSamples       = 5
+	defaultCircuitBreakerOpenTimeout      = 30 * time.Second
+)
+
+// cbState is the circuit breaker's current state.
+type cbState int
+
+const (
+	cbClosed cbState = iota
+	cbOpen
+	cbHalfOpen
+)
+
+// circuitBreaker tracks a single backend's recent call outcomes and trips
+// between closed, open, and half-open, independently of that backend's
+// active-health-check status.
+type circuitBreaker struct {
+	mu       sync.Mutex
+	state    cbState
+	failures int
+	total    int
+	openedAt time.Time
+}
+
+// allow reports whether a request may currently be sent to this backend,
+// transitioning an open breaker to half-open once its cooldown has elapsed.
+func (cb *circuitBreaker) allow(config CircuitBreakerConfig) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == cbOpen {
+		if time.Since(cb.openedAt) < openTimeout(config) {
+			return false
+		}
+		cb.state = cbHalfOpen
+	}
+	return true
+}
+
+// recordResult folds a call outcome into the breaker's counters, tripping it
+// open once the failure ratio crosses config's threshold over at least
+// config's minimum sample size. A half-open probe decides the outcome
+// immediately: success closes the breaker, failure reopens it.
+func (cb *circuitBreaker) recordResult(config CircuitBreakerConfig, success bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == cbHalfOpen {
+		cb.failures, cb.total = 0, 0
+		if success {
+			cb.state = cbClosed
+		} else {
+			cb.state = cbOpen
+			cb.openedAt = time.Now()
+		}
+		return
+	}
+
+	cb.total++
+	if !success {
+		cb.failures++
+	}
+
+	minSamples := config.MinSamples
+	if minSamples <= 0 {
+		minSamples = defaultCircuitBreakerMinSamples
+	}
+	threshold := config.FailureThreshold
+	if threshold <= 0 {
+		threshold = defaultCircuitBreakerFailureThreshold
+	}
+
+	if cb.total >= minSamples && float64(cb.failures)/float64(cb.total) >= threshold {
+		cb.state = cbOpen
+		cb.openedAt = time.Now()
+		cb.failures, cb.total = 0, 0
+	}
+}
+
+func openTimeout(config CircuitBreakerConfig) time.Duration {
+	if config.OpenTimeout == "" {
+		return defaultCircuitBreakerOpenTimeout
+	}
+	d, err := time.ParseDuration(config.OpenTimeout)
+	if err != nil || d <= 0 {
+		return defaultCircuitBreakerOpenTimeout
+	}
+	return d
+}
+
+var (
+	circuitBreakersMu sync.Mutex
+	circuitBreakers   = make(map[string]*circuitBreaker)
+)
+
+// getOrCreateCircuitBreaker returns the shared breaker for backendURL,
+// creating a fresh closed breaker on first use.
+func getOrCreateCircuitBreaker(backendURL string) *circuitBreaker {
+	circuitBreakersMu.Lock()
+	defer circuitBreakersMu.Unlock()
+
+	cb, ok := circuitBreakers[backendURL]
+	if !ok {
+		cb = &circuitBreaker{}
+		circuitBreakers[backendURL] = cb
+	}
+	return cb
+}
+
+// backendEligible reports whether backendURL is both passing active health
+// checks and not currently tripped open by its circuit breaker.
+func backendEligible(backendURL string, cbConfig CircuitBreakerConfig) bool {
+	return getOrCreateBackendState(backendURL).isHealthy() && getOrCreateCircuitBreaker(backendURL).allow(cbConfig)
+}
+
+// eligibleBackendsFrom filters backends down to those currently eligible to
+// receive traffic, preserving order.
+func eligibleBackendsFrom(backends []string, cbConfig CircuitBreakerConfig) []string {
+	eligible := make([]string, 0, len(backends))
+	for _, backendURL := range backends {
+		if backendEligible(backendURL, cbConfig) {
+			eligible = append(eligible, backendURL)
+		}
+	}
+	return eligible
+}
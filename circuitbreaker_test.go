@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTripsAfterFailureThreshold(t *testing.T) {
+	cb := &circuitBreaker{}
+	config := CircuitBreakerConfig{FailureThreshold: 0.5, MinSamples: 4, OpenTimeout: "50ms"}
+
+	if !cb.allow(config) {
+		t.Fatal("expected a fresh breaker to allow requests")
+	}
+
+	// 2 failures out of 4 samples hits the 0.5 threshold.
+	cb.recordResult(config, true)
+	cb.recordResult(config, false)
+	cb.recordResult(config, true)
+	cb.recordResult(config, false)
+
+	if cb.allow(config) {
+		t.Error("expected breaker to be open after crossing the failure threshold")
+	}
+}
+
+func TestCircuitBreakerHalfOpenRecovery(t *testing.T) {
+	cb := &circuitBreaker{}
+	config := CircuitBreakerConfig{FailureThreshold: 0.5, MinSamples: 2, OpenTimeout: "20ms"}
+
+	cb.recordResult(config, false)
+	cb.recordResult(config, false)
+
+	if cb.allow(config) {
+		t.Fatal("expected breaker to be open immediately after tripping")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	if !cb.allow(config) {
+		t.Fatal("expected breaker to allow a half-open probe once the cooldown elapsed")
+	}
+
+	// A successful half-open probe closes the breaker.
+	cb.recordResult(config, true)
+	if !cb.allow(config) {
+		t.Error("expected breaker to stay closed after a successful probe")
+	}
+}
+
+func TestCircuitBreakerHalfOpenFailureReopens(t *testing.T) {
+	cb := &circuitBreaker{}
+	config := CircuitBreakerConfig{FailureThreshold: 0.5, MinSamples: 2, OpenTimeout: "20ms"}
+
+	cb.recordResult(config, false)
+	cb.recordResult(config, false)
+	time.Sleep(30 * time.Millisecond)
+
+	if !cb.allow(config) {
+		t.Fatal("expected breaker to allow a half-open probe once the cooldown elapsed")
+	}
+
+	// A failed half-open probe reopens the breaker immediately.
+	cb.recordResult(config, false)
+	if cb.allow(config) {
+		t.Error("expected breaker to reopen after a failed half-open probe")
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LoadBalancer owns the current set of backend URLs a Balancer may pick
+// from. Per-backend bookkeeping (health, weight, in-flight count) stays in
+// the URL-keyed backendState registry; LoadBalancer tracks only membership
+// and drain status, so the admin API can add, remove, and drain backends at
+// runtime without the load-balancing strategies needing to be reconstructed.
+type LoadBalancer struct {
+	mu      sync.RWMutex
+	order   []string
+	drained map[string]bool
+}
+
+// NewLoadBalancer returns a LoadBalancer seeded with backendURLs.
+func NewLoadBalancer(backendURLs []string) *LoadBalancer {
+	lb := &LoadBalancer{drained: make(map[string]bool)}
+	lb.order = append(lb.order, backendURLs...)
+	return lb
+}
+
+// Backends returns the currently registered backend URLs, excluding any
+// taken out of rotation by Drain, in configured/insertion order.
+func (lb *LoadBalancer) Backends() []string {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	backends := make([]string, 0, len(lb.order))
+	for _, backendURL := range lb.order {
+		if !lb.drained[backendURL] {
+			backends = append(backends, backendURL)
+		}
+	}
+	return backends
+}
+
+// All returns every registered backend URL, including drained ones, for
+// admin listing and health checks (a drained backend keeps being probed so
+// it can rejoin rotation once undrained).
+func (lb *LoadBalancer) All() []string {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	return append([]string(nil), lb.order...)
+}
+
+// IsDrained reports whether backendURL is currently excluded from new picks.
+func (lb *LoadBalancer) IsDrained(backendURL string) bool {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	return lb.drained[backendURL]
+}
+
+// Add registers backendURL, undraining it if it was already known. A no-op
+// beyond that if the backend is already registered.
+func (lb *LoadBalancer) Add(backendURL string) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	for _, existing := range lb.order {
+		if existing == backendURL {
+			delete(lb.drained, backendURL)
+			return
+		}
+	}
+	lb.order = append(lb.order, backendURL)
+}
+
+// Sync adds any backend URLs from backendURLs that aren't already
+// registered. Existing backends (and any added at runtime via Add) are left
+// untouched, so this is safe to call on every request to keep the
+// LoadBalancer aligned with the last-loaded Config without reconstructing it.
+func (lb *LoadBalancer) Sync(backendURLs []string) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	for _, backendURL := range backendURLs {
+		found := false
+		for _, existing := range lb.order {
+			if existing == backendURL {
+				found = true
+				break
+			}
+		}
+		if !found {
+			lb.order = append(lb.order, backendURL)
+		}
+	}
+}
+
+// Remove unregisters backendURL entirely. In-flight requests already
+// dispatched to it are unaffected. It reports whether the backend was known.
+func (lb *LoadBalancer) Remove(backendURL string) bool {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	for i, existing := range lb.order {
+		if existing == backendURL {
+			lb.order = append(lb.order[:i], lb.order[i+1:]...)
+			delete(lb.drained, backendURL)
+			return true
+		}
+	}
+	return false
+}
+
+// Drain takes backendURL out of rotation for new picks, then blocks until
+// its in-flight request count reaches zero or timeout elapses. It reports
+// whether the backend drained cleanly within timeout. Only the least_conn and
+// p2c strategies count in-flight requests; under round_robin and ip_hash the
+// count stays at zero, so Drain returns as soon as the backend is out of
+// rotation, without waiting for requests already dispatched to it.
+func (lb *LoadBalancer) Drain(backendURL string, timeout time.Duration) bool {
+	lb.mu.Lock()
+	lb.drained[backendURL] = true
+	lb.mu.Unlock()
+
+	state := getOrCreateBackendState(backendURL)
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadInt64(&state.inFlight) > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
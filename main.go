@@ -7,164 +7,445 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
 	"os"
-	"sync"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
+// hopByHopHeaders are the headers defined by RFC 7230 §6.1 that are specific
+// to a single transport-level connection and must not be forwarded as-is
+// between the client, the proxy, and the backend.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailers",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// isUpgradeRequest reports whether header describes a protocol upgrade (e.g.
+// a WebSocket handshake), which must keep its Connection and Upgrade headers
+// intact end-to-end rather than having them stripped as hop-by-hop.
+func isUpgradeRequest(header http.Header) bool {
+	return strings.EqualFold(header.Get("Connection"), "Upgrade") && header.Get("Upgrade") != ""
+}
+
+// removeHopByHopHeaders strips hop-by-hop headers from header in place,
+// including any additional headers named in a Connection header. Upgrade
+// requests are left untouched so WebSocket and similar handshakes pass
+// through unmodified.
+func removeHopByHopHeaders(header http.Header) {
+	if isUpgradeRequest(header) {
+		return
+	}
+	if connection := header.Get("Connection"); connection != "" {
+		for _, name := range strings.Split(connection, ",") {
+			header.Del(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+}
+
+// HealthCheckConfig configures the active health probe sent to each backend.
+type HealthCheckConfig struct {
+	Path                string            `json:"path"`                  // Path appended to the backend URL, e.g. "/health"
+	Timeout             string            `json:"timeout"`               // Per-probe timeout, parsed with time.ParseDuration
+	ExpectedStatusCodes []int             `json:"expected_status_codes"` // Acceptable status codes; defaults to 200-308 when empty
+	Hostname            string            `json:"hostname"`              // Optional Host header override for the probe
+	Headers             map[string]string `json:"headers"`               // Optional extra headers sent with the probe
+}
+
 type Config struct {
-	HealthCheckInterval string   `json:"health_check_interval"`
-	FrontendPort        string   `json:"frontend_port"`
-	BackendURLs         []string `json:"backend_urls"`
+	HealthCheckInterval string            `json:"health_check_interval"`
+	HealthCheck         HealthCheckConfig `json:"health_check"`
+	FrontendPort        string            `json:"frontend_port"`
+	// AdminPort, when set, starts a separate admin HTTP server exposing
+	// backend status and runtime control endpoints under /admin. See
+	// admin.go.
+	AdminPort   string   `json:"admin_port,omitempty"`
+	BackendURLs []string `json:"backend_urls"`
+	// Strategy selects the load-balancing algorithm: "round_robin" (default,
+	// weighted by BackendWeights), "least_conn", "ip_hash", or "p2c".
+	Strategy string `json:"strategy,omitempty"`
+	// BackendWeights optionally overrides the default weight of 1 for
+	// round_robin, keyed by backend URL.
+	BackendWeights map[string]int       `json:"backend_weights,omitempty"`
+	CircuitBreaker CircuitBreakerConfig `json:"circuit_breaker,omitempty"`
+	Retry          RetryConfig          `json:"retry,omitempty"`
 }
 
+// liveConfig holds the Config and Balancer currently in effect for a running
+// server, letting POST /admin/reload (see admin.go) swap in a freshly loaded
+// Config's strategy, circuit breaker, and retry settings without a process
+// restart. Backend membership/weights are reloaded separately through lb and
+// setBackendWeight, since those are already safe to mutate underneath a
+// live Balancer.
+type liveConfig struct {
+	v atomic.Value // holds a *liveConfigValue
+}
+
+// liveConfigValue is the Config/Balancer pair stored atomically by
+// liveConfig; storing the pair together means a reader always sees a
+// Balancer that was built from the Config it's paired with.
+type liveConfigValue struct {
+	config   Config
+	balancer Balancer
+}
+
+// newLiveConfig builds a liveConfig seeded with config, constructing its
+// initial Balancer against lb.
+func newLiveConfig(lb *LoadBalancer, config Config) *liveConfig {
+	lc := &liveConfig{}
+	lc.store(lb, config)
+	return lc
+}
+
+// store installs config as the active Config, rebuilding its Balancer
+// against lb.
+func (lc *liveConfig) store(lb *LoadBalancer, config Config) {
+	lc.v.Store(&liveConfigValue{config: config, balancer: newBalancer(lb, config)})
+}
+
+// load returns the currently active Config and Balancer.
+func (lc *liveConfig) load() (Config, Balancer) {
+	value := lc.v.Load().(*liveConfigValue)
+	return value.config, value.balancer
+}
+
+// defaultHealthCheckInterval is used when a caller invokes healthCheck
+// without going through StartServer's interval validation.
+const defaultHealthCheckInterval = 10 * time.Second
+
 var (
-	healthCheckInterval time.Duration           // Health check interval
-	healthyBackends     = make(map[string]bool) // Map to track backend server health
-	mu                  sync.Mutex              // Mutex to protect backend selection
-	backendIndex        int                     // Index to track the next backend to which the request should be forwarded
+	healthCheckInterval time.Duration // Health check interval
+	healthCheckTimeout  time.Duration // Per-probe timeout
 )
 
-// frontendHandler handles incoming requests, forwards them to the backend, and returns the combined response.
-func frontendHandler(config Config) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		clientIP := r.RemoteAddr
-		method := r.Method
-		url := r.URL.RequestURI() // Use RequestURI to get the path and query string
-		protocol := r.Proto
-		host := r.Host
-		userAgent := r.Header.Get("User-Agent")
-		accept := r.Header.Get("Accept")
-
-		requestDetails := fmt.Sprintf("Received request from %s\n%s %s %s\nHost: %s\nUser-Agent: %s\nAccept: %s\n",
-			clientIP, method, url, protocol, host, userAgent, accept)
-
-		fmt.Println(requestDetails) // Log the request details
-
-		// Forward the request to a healthy backend server using round-robin
-		backendBaseURL := getNextHealthyBackendURL(config)
-		if backendBaseURL == "" {
-			http.Error(w, "No healthy backend available", http.StatusServiceUnavailable)
-			return
-		}
+// defaultExpectedStatusCodes returns the status codes treated as healthy when
+// Config.HealthCheck.ExpectedStatusCodes is not set: any 2xx, plus 3xx up
+// through 308 Permanent Redirect.
+func defaultExpectedStatusCodes() []int {
+	codes := make([]int, 0, 10)
+	for code := 200; code < 300; code++ {
+		codes = append(codes, code)
+	}
+	for code := 300; code <= http.StatusPermanentRedirect; code++ {
+		codes = append(codes, code)
+	}
+	return codes
+}
 
-		backendURL := backendBaseURL + url
-		backendReq, err := http.NewRequest(method, backendURL, r.Body)
-		if err != nil {
-			http.Error(w, "Error creating request to backend", http.StatusInternalServerError)
-			return
+// isExpectedStatus reports whether statusCode is one of the acceptable codes.
+func isExpectedStatus(statusCode int, expected []int) bool {
+	if len(expected) == 0 {
+		expected = defaultExpectedStatusCodes()
+	}
+	for _, code := range expected {
+		if code == statusCode {
+			return true
 		}
+	}
+	return false
+}
+
+// frontendHandler handles incoming requests, picks a backend using the
+// active Config's load-balancing strategy, and streams the request and
+// response through a reverse proxy. Idempotent requests are retried on
+// connection errors or 5xx responses, up to the active Config's Retry
+// attempt limit; each retry's Pick is told which backends this request
+// already tried (see withExcludedBackend), which ip_hash and least_conn use
+// to pick another backend when one is eligible, while round_robin and p2c
+// pick as usual. Each backend's circuit breaker is
+// updated with the outcome of every attempt. When retries are possible, the
+// request body (if any) is buffered once so it can be replayed on each
+// attempt. lb is the backend registry this handler reads from; lc is the
+// Config/Balancer pair in effect, which POST /admin/reload (see admin.go)
+// may swap at runtime. Each request reads lc once, so a reload never changes
+// the settings applied mid-request.
+func frontendHandler(lb *LoadBalancer, lc *liveConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config, balancer := lc.load()
 
-		// Copy headers
-		for name, values := range r.Header {
-			for _, value := range values {
-				backendReq.Header.Add(name, value)
+		fmt.Printf("Received request from %s\n%s %s %s\nHost: %s\nUser-Agent: %s\nAccept: %s\n\n",
+			r.RemoteAddr, r.Method, r.URL.RequestURI(), r.Proto, r.Host, r.Header.Get("User-Agent"), r.Header.Get("Accept"))
+
+		maxAttempts := maxAttemptsFor(r.Method, config.Retry)
+		if maxAttempts > 1 {
+			if err := bufferRequestBodyForRetry(r); err != nil {
+				http.Error(w, "Error reading request body", http.StatusInternalServerError)
+				return
 			}
 		}
 
-		client := &http.Client{}
-		backendResp, err := client.Do(backendReq)
-		if err != nil {
-			fmt.Println(err)
-			http.Error(w, "Error forwarding request to backend", http.StatusInternalServerError)
-			return
-		}
-		defer backendResp.Body.Close()
+		for attempt := 1; attempt <= maxAttempts; attempt++ {
+			if attempt > 1 && r.GetBody != nil {
+				body, err := r.GetBody()
+				if err != nil {
+					http.Error(w, "Error reading request body", http.StatusInternalServerError)
+					return
+				}
+				r.Body = body
+			}
 
-		// Copy backend response headers and status code
-		for name, values := range backendResp.Header {
-			for _, value := range values {
-				w.Header().Add(name, value)
+			backendBaseURL, release, err := balancer.Pick(r)
+			if err != nil {
+				http.Error(w, "No healthy backend available", http.StatusServiceUnavailable)
+				return
 			}
-		}
-		w.WriteHeader(backendResp.StatusCode)
 
-		// Log the response status from the backend
-		fmt.Printf("Response from server: %s\n\n", backendResp.Status)
+			breaker := getOrCreateCircuitBreaker(backendBaseURL)
+			if !breaker.allow(config.CircuitBreaker) {
+				release()
+				if attempt == maxAttempts {
+					http.Error(w, "No healthy backend available", http.StatusServiceUnavailable)
+					return
+				}
+				continue
+			}
 
-		// Read the backend response body
-		body, err := io.ReadAll(backendResp.Body)
-		if err != nil {
-			http.Error(w, "Error reading response from backend", http.StatusInternalServerError)
-			return
-		}
+			target, err := url.Parse(backendBaseURL)
+			if err != nil {
+				release()
+				http.Error(w, "Invalid backend URL", http.StatusInternalServerError)
+				return
+			}
 
-		// Print the backend response body
-		fmt.Println(string(body))
+			attemptResult := &proxyAttemptResult{}
+			newReverseProxy(target, attemptResult, attempt < maxAttempts).ServeHTTP(w, r)
+			release()
+			breaker.recordResult(config.CircuitBreaker, !attemptResult.backendFailed)
 
-		// Write the backend response body to the client
-		if _, err := io.Copy(w, bytes.NewReader(body)); err != nil {
-			http.Error(w, "Error writing response to client", http.StatusInternalServerError)
-			return
+			if attemptResult.done {
+				return
+			}
+			r = withExcludedBackend(r, backendBaseURL)
 		}
 	}
 }
 
-// healthCheck periodically checks the health of backend servers.
-func healthCheck(config Config) {
-	for {
-		for _, backendURL := range config.BackendURLs {
-			resp, err := http.Get(backendURL)
-			if err != nil || resp.StatusCode != http.StatusOK {
-				setBackendHealth(backendURL, false)
-			} else {
-				setBackendHealth(backendURL, true)
+// bufferRequestBodyForRetry reads r's body into memory once and installs
+// r.GetBody so each retry attempt in frontendHandler can replay it from a
+// fresh reader. Without this, ReverseProxy fully drains and closes r.Body
+// while forwarding the first attempt, so a retried request with a body
+// (e.g. PUT) would reach the next backend with no body at all. A no-op when
+// r carries no body.
+func bufferRequestBodyForRetry(r *http.Request) error {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	r.Body, _ = r.GetBody()
+	return nil
+}
+
+// proxyAttemptResult reports how a single reverse-proxy attempt concluded:
+// whether the backend call failed (for the circuit breaker) and whether the
+// attempt reached a final outcome that was written to the client (for the
+// retry loop).
+type proxyAttemptResult struct {
+	backendFailed bool
+	done          bool
+}
+
+// newReverseProxy builds a reverse proxy that forwards requests to target,
+// streaming the body rather than buffering it, with hop-by-hop headers
+// stripped in both directions and X-Forwarded-* headers set on the outbound
+// request. When retryable is true, a connection error or 5xx response is
+// recorded on result without being written to the client, so frontendHandler
+// can retry against another backend; otherwise the response (including a
+// final 5xx) is passed through as-is.
+func newReverseProxy(target *url.URL, result *proxyAttemptResult, retryable bool) *httputil.ReverseProxy {
+	director := func(req *http.Request) {
+		originalHost := req.Host
+		scheme := "http"
+		if req.TLS != nil {
+			scheme = "https"
+		}
+
+		req.URL.Scheme = target.Scheme
+		req.URL.Host = target.Host
+
+		// httputil.ReverseProxy appends X-Forwarded-For itself; we only need
+		// to add the headers it doesn't set.
+		req.Header.Set("X-Forwarded-Proto", scheme)
+		req.Header.Set("X-Forwarded-Host", originalHost)
+
+		removeHopByHopHeaders(req.Header)
+	}
+
+	return &httputil.ReverseProxy{
+		Director: director,
+		ModifyResponse: func(resp *http.Response) error {
+			removeHopByHopHeaders(resp.Header)
+			fmt.Printf("Response from server: %s\n\n", resp.Status)
+
+			if resp.StatusCode >= http.StatusInternalServerError {
+				result.backendFailed = true
+				if retryable {
+					return errRetryableBackendError
+				}
 			}
-			if resp != nil {
-				resp.Body.Close()
+			result.done = true
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			result.backendFailed = true
+			if retryable {
+				return
 			}
-		}
-		time.Sleep(healthCheckInterval)
+			if err != errRetryableBackendError {
+				fmt.Println(err)
+			}
+			http.Error(w, "Error forwarding request to backend", http.StatusBadGateway)
+			result.done = true
+		},
 	}
 }
 
-// getNextHealthyBackendURL returns the next healthy backend URL in a round-robin manner.
-func getNextHealthyBackendURL(config Config) string {
-	mu.Lock()
-	defer mu.Unlock()
+// healthCheck periodically probes backend servers and updates their health
+// status on every tick of config.HealthCheckInterval. It does not probe
+// before entering its ticker loop; callers that must not accept traffic
+// while every backend still shows unknown/unhealthy (StartServer) should run
+// probeAll synchronously themselves before starting healthCheck.
+func healthCheck(lb *LoadBalancer, config Config) {
+	interval, err := time.ParseDuration(config.HealthCheckInterval)
+	if err != nil || interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
 
-	// Ensure we always start checking from the current backendIndex
-	startIndex := backendIndex
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-	for {
-		// Increment backendIndex and wrap around if necessary
-		backendIndex = (backendIndex + 1) % len(config.BackendURLs)
+	for range ticker.C {
+		probeAll(lb, config)
+	}
+}
 
-		// Check if the backend at the current index is healthy
-		if healthy, ok := healthyBackends[config.BackendURLs[backendIndex]]; ok && healthy {
-			return config.BackendURLs[backendIndex]
-		}
+// probeAll runs a single health-check pass across every backend currently
+// registered with lb (not just config.BackendURLs), so backends added at
+// runtime through the admin API are probed too, and one removed via DELETE
+// /admin/backends/{url} stays removed rather than reappearing on the next
+// tick. Drained backends are still probed, so they're ready to rejoin
+// rotation once undrained. It never registers new backends itself; lb's
+// membership only changes through NewLoadBalancer at startup, the admin
+// API's add/remove/drain endpoints, and an explicit POST /admin/reload.
+func probeAll(lb *LoadBalancer, config Config) {
+	for _, backendURL := range lb.All() {
+		healthy := probeBackend(config, backendURL)
+		setBackendHealth(backendURL, healthy)
+	}
+}
 
-		// If we have checked all backends and none are healthy, return an empty string
-		if backendIndex == startIndex {
-			return ""
-		}
+// probeBackend issues a single health probe against backendURL using the
+// configured path, timeout, hostname, and headers, and reports whether the
+// response status is one of the expected codes.
+func probeBackend(config Config, backendURL string) bool {
+	probeURL := backendURL + config.HealthCheck.Path
+
+	req, err := http.NewRequest(http.MethodGet, probeURL, nil)
+	if err != nil {
+		return false
+	}
+	if config.HealthCheck.Hostname != "" {
+		req.Host = config.HealthCheck.Hostname
 	}
+	for name, value := range config.HealthCheck.Headers {
+		req.Header.Set(name, value)
+	}
+
+	client := &http.Client{Timeout: healthCheckTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	return isExpectedStatus(resp.StatusCode, config.HealthCheck.ExpectedStatusCodes)
 }
 
-// setBackendHealth sets the health status of a backend server.
+// setBackendHealth sets the health status of a backend server, logging at
+// WARN level whenever a backend transitions between healthy and unhealthy.
 func setBackendHealth(backendURL string, healthy bool) {
-	mu.Lock()
-	defer mu.Unlock()
-	healthyBackends[backendURL] = healthy
+	state := getOrCreateBackendState(backendURL)
+
+	state.mu.Lock()
+	previous, known := state.healthy, state.observed
+	state.healthy = healthy
+	state.observed = true
+	state.lastProbeAt = time.Now()
+	state.mu.Unlock()
+
+	if known && previous != healthy {
+		if healthy {
+			log.Printf("WARN: backend %s transitioned from unhealthy to healthy\n", backendURL)
+		} else {
+			log.Printf("WARN: backend %s transitioned from healthy to unhealthy\n", backendURL)
+		}
+	}
 }
 
 // StartServer starts the load balancer server
 func StartServer(config Config) {
-	// Parse the health check interval
+	// Parse the health check interval and timeout
 	var err error
 	healthCheckInterval, err = time.ParseDuration(config.HealthCheckInterval)
 	if err != nil {
 		log.Fatalf("Invalid health check interval: %s\n", err)
 	}
 
-	// Start the backend health checker
-	go healthCheck(config)
+	if config.HealthCheck.Timeout != "" {
+		healthCheckTimeout, err = time.ParseDuration(config.HealthCheck.Timeout)
+		if err != nil {
+			log.Fatalf("Invalid health check timeout: %s\n", err)
+		}
+	} else {
+		healthCheckTimeout = healthCheckInterval
+	}
+
+	// lb owns the mutable backend set for this server instance, shared by the
+	// frontend handler, the health checker, and the admin API below.
+	lb := NewLoadBalancer(config.BackendURLs)
+
+	// lc owns the Strategy/CircuitBreaker/Retry settings currently in
+	// effect, which POST /admin/reload (see admin.go) may swap out.
+	lc := newLiveConfig(lb, config)
+
+	// Run an initial synchronous health probe so we don't start accepting
+	// traffic while every backend still shows unknown/unhealthy, then hand
+	// off to the periodic checker.
+	probeAll(lb, config)
+	go healthCheck(lb, config)
+
+	if config.AdminPort != "" {
+		go func() {
+			fmt.Printf("Starting admin server on port %s\n", config.AdminPort)
+			if err := http.ListenAndServe(":"+config.AdminPort, newAdminMux(lb, lc)); err != nil {
+				log.Fatalf("Could not start admin server: %s\n", err)
+			}
+		}()
+	}
 
 	// Start the frontend server
 	port := config.FrontendPort
 	frontendMux := http.NewServeMux()
-	frontendMux.HandleFunc("/", frontendHandler(config))
+	frontendMux.HandleFunc("/", frontendHandler(lb, lc))
 	fmt.Printf("Starting frontend server on port %s\n", port)
 	err = http.ListenAndServe(":"+port, frontendMux)
 	if err != nil {
@@ -172,18 +453,26 @@ func StartServer(config Config) {
 	}
 }
 
-func main() {
-	// Load configuration
-	file, err := os.Open("config.json")
+// loadConfigFile reads and parses a Config from path, used both at startup
+// and by the admin API's reload endpoint.
+func loadConfigFile(path string) (Config, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		log.Fatalf("Error opening config file: %s\n", err)
+		return Config{}, fmt.Errorf("opening config file: %w", err)
 	}
 	defer file.Close()
 
 	var config Config
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&config); err != nil {
-		log.Fatalf("Error parsing config file: %s\n", err)
+	if err := json.NewDecoder(file).Decode(&config); err != nil {
+		return Config{}, fmt.Errorf("parsing config file: %w", err)
+	}
+	return config, nil
+}
+
+func main() {
+	config, err := loadConfigFile("config.json")
+	if err != nil {
+		log.Fatalf("Error loading config: %s\n", err)
 	}
 
 	StartServer(config)
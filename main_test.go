@@ -1,9 +1,12 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
 )
@@ -24,7 +27,8 @@ func TestFrontendHandler(t *testing.T) {
 	// Directly set the backends as healthy
 	setBackendHealth(mockBackend1.URL, true)
 
-	handler := frontendHandler(config)
+	lb := NewLoadBalancer(config.BackendURLs)
+	handler := frontendHandler(lb, newLiveConfig(lb, config))
 
 	req, err := http.NewRequest("GET", "http://localhost:9090", nil)
 	if err != nil {
@@ -56,7 +60,8 @@ func TestFrontendHandlerNoHealthyBackend(t *testing.T) {
 		BackendURLs:         []string{"http://localhost:9091"}, // Non-existent backend
 	}
 
-	handler := frontendHandler(config)
+	lb := NewLoadBalancer(config.BackendURLs)
+	handler := frontendHandler(lb, newLiveConfig(lb, config))
 
 	req, err := http.NewRequest("GET", "http://localhost", nil)
 	if err != nil {
@@ -81,6 +86,124 @@ func TestFrontendHandlerNoHealthyBackend(t *testing.T) {
 	}
 }
 
+// TestReverseProxyStripsHopByHopHeaders verifies that hop-by-hop headers on
+// the backend response are stripped before being copied to the client, while
+// ordinary headers survive untouched.
+func TestReverseProxyStripsHopByHopHeaders(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Connection", "close")
+		w.Header().Set("X-Custom", "keep-me")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	config := Config{BackendURLs: []string{backend.URL}}
+	setBackendHealth(backend.URL, true)
+
+	req, err := http.NewRequest("GET", "http://localhost", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "192.168.1.1:12345"
+
+	lb := NewLoadBalancer(config.BackendURLs)
+	rr := httptest.NewRecorder()
+	frontendHandler(lb, newLiveConfig(lb, config))(rr, req)
+
+	if got := rr.Header().Get("Connection"); got != "" {
+		t.Errorf("expected Connection header to be stripped, got %q", got)
+	}
+	if got := rr.Header().Get("X-Custom"); got != "keep-me" {
+		t.Errorf("expected X-Custom header to survive, got %q", got)
+	}
+}
+
+// TestReverseProxyAppendsXForwardedFor verifies that an existing
+// X-Forwarded-For header is appended to, not replaced, when the request
+// already carries one.
+func TestReverseProxyAppendsXForwardedFor(t *testing.T) {
+	var gotXFF string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotXFF = r.Header.Get("X-Forwarded-For")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	config := Config{BackendURLs: []string{backend.URL}}
+	setBackendHealth(backend.URL, true)
+
+	req, err := http.NewRequest("GET", "http://localhost", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "203.0.113.5:54321"
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+
+	lb := NewLoadBalancer(config.BackendURLs)
+	rr := httptest.NewRecorder()
+	frontendHandler(lb, newLiveConfig(lb, config))(rr, req)
+
+	want := "10.0.0.1, 203.0.113.5"
+	if gotXFF != want {
+		t.Errorf("X-Forwarded-For = %q, want %q", gotXFF, want)
+	}
+}
+
+// TestReverseProxyStreamsResponseBody verifies that a streamed backend
+// response reaches the client as it is written, rather than being buffered
+// in full before the first byte is forwarded.
+func TestReverseProxyStreamsResponseBody(t *testing.T) {
+	const delay = 200 * time.Millisecond
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("ResponseWriter does not support flushing")
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "first-chunk\n")
+		flusher.Flush()
+		time.Sleep(delay)
+		fmt.Fprint(w, "second-chunk\n")
+	}))
+	defer backend.Close()
+
+	config := Config{BackendURLs: []string{backend.URL}}
+	setBackendHealth(backend.URL, true)
+
+	lb := NewLoadBalancer(config.BackendURLs)
+	frontend := httptest.NewServer(frontendHandler(lb, newLiveConfig(lb, config)))
+	defer frontend.Close()
+
+	conn, err := net.Dial("tcp", frontend.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", frontend.Listener.Addr().String())
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+
+	if line != "first-chunk\n" {
+		t.Fatalf("unexpected first chunk: %q", line)
+	}
+	if elapsed >= delay {
+		t.Errorf("first chunk arrived after %s, which is not faster than the backend's %s delay before its second chunk; response appears to be buffered rather than streamed", elapsed, delay)
+	}
+}
+
 func TestHealthCheck(t *testing.T) {
 	mockBackend1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -98,17 +221,17 @@ func TestHealthCheck(t *testing.T) {
 	}
 
 	// Start health check
-	go healthCheck(config)
+	go healthCheck(NewLoadBalancer(config.BackendURLs), config)
 
 	// Allow some time for health checks to run
 	time.Sleep(300 * time.Millisecond)
 
 	// Check backend health status
-	if !healthyBackends[mockBackend1.URL] {
+	if !isBackendHealthy(mockBackend1.URL) {
 		t.Errorf("Backend %s should be healthy", mockBackend1.URL)
 	}
 
-	if healthyBackends[mockBackend2.URL] {
+	if isBackendHealthy(mockBackend2.URL) {
 		t.Errorf("Backend %s should be unhealthy", mockBackend2.URL)
 	}
 }
@@ -153,7 +276,8 @@ func TestRoundRobinLoadBalancing(t *testing.T) {
 
 		rr := httptest.NewRecorder()
 
-		handler := frontendHandler(config)
+		lb := NewLoadBalancer(config.BackendURLs)
+		handler := frontendHandler(lb, newLiveConfig(lb, config))
 		handler(rr, req)
 
 		fmt.Println(rr.Body.String())
@@ -174,17 +298,68 @@ func TestRoundRobinLoadBalancing(t *testing.T) {
 	}
 }
 
+// TestHealthCheckStatusSequences drives probeBackend through scripted status
+// sequences and asserts the resulting healthy/unhealthy transitions.
+func TestHealthCheckStatusSequences(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int  // one status code per probe, in order
+		want     []bool // expected healthy result after each probe
+	}{
+		{
+			name:     "start healthy then sick",
+			statuses: []int{http.StatusOK, http.StatusServiceUnavailable},
+			want:     []bool{true, false},
+		},
+		{
+			name:     "sick then healthy",
+			statuses: []int{http.StatusServiceUnavailable, http.StatusOK},
+			want:     []bool{false, true},
+		},
+		{
+			name:     "toggling",
+			statuses: []int{http.StatusOK, http.StatusServiceUnavailable, http.StatusOK, http.StatusServiceUnavailable},
+			want:     []bool{true, false, true, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			step := 0
+
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				status := tt.statuses[step]
+				step++
+				mu.Unlock()
+				w.WriteHeader(status)
+			}))
+			defer backend.Close()
+
+			config := Config{BackendURLs: []string{backend.URL}}
+
+			for i, want := range tt.want {
+				got := probeBackend(config, backend.URL)
+				if got != want {
+					t.Errorf("probe %d: got healthy=%v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
 func TestSetBackendHealth(t *testing.T) {
 	backendURL := "http://localhost:9090"
 	setBackendHealth(backendURL, true)
 
-	if !healthyBackends[backendURL] {
+	if !isBackendHealthy(backendURL) {
 		t.Errorf("Expected backend %s to be healthy", backendURL)
 	}
 
 	setBackendHealth(backendURL, false)
 
-	if healthyBackends[backendURL] {
+	if isBackendHealthy(backendURL) {
 		t.Errorf("Expected backend %s to be unhealthy", backendURL)
 	}
 }
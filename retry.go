@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// errRetryableBackendError signals that a reverse-proxy attempt failed in a
+// way frontendHandler should retry against another backend, rather than an
+// error to surface to the client.
+var errRetryableBackendError = errors.New("backend responded with a retryable error")
+
+// RetryConfig configures the bounded retry policy applied to idempotent
+// requests.
+type RetryConfig struct {
+	// MaxAttempts is the total number of attempts, including the first;
+	// values <= 1 disable retries. Only idempotent methods are retried.
+	MaxAttempts int `json:"max_attempts,omitempty"`
+}
+
+// isIdempotentMethod reports whether method is safe to retry against a
+// different backend after a connection error or 5xx response.
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+// maxAttemptsFor returns how many attempts a request for method may take
+// under retry: always 1 for non-idempotent methods, otherwise retry.MaxAttempts
+// (or 1 if that's left unset).
+func maxAttemptsFor(method string, retry RetryConfig) int {
+	if !isIdempotentMethod(method) || retry.MaxAttempts <= 1 {
+		return 1
+	}
+	return retry.MaxAttempts
+}
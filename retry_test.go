@@ -0,0 +1,221 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// TestRetryRetriesIdempotentRequestOnBackendFiveXX verifies that a GET
+// request retries against the next backend when the first one returns a
+// 5xx, and that the client ultimately receives the successful response.
+func TestRetryRetriesIdempotentRequestOnBackendFiveXX(t *testing.T) {
+	var badCalls, goodCalls int32
+
+	badBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&badCalls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer badBackend.Close()
+
+	goodBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&goodCalls, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer goodBackend.Close()
+
+	setBackendHealth(badBackend.URL, true)
+	setBackendHealth(goodBackend.URL, true)
+
+	config := Config{
+		BackendURLs: []string{badBackend.URL, goodBackend.URL},
+		Retry:       RetryConfig{MaxAttempts: 2},
+	}
+
+	req, err := http.NewRequest("GET", "http://localhost", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "192.168.1.1:12345"
+
+	lb := NewLoadBalancer(config.BackendURLs)
+	rr := httptest.NewRecorder()
+	frontendHandler(lb, newLiveConfig(lb, config))(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected client to see the eventual success, got status %d", rr.Code)
+	}
+	if got := atomic.LoadInt32(&badCalls); got != 1 {
+		t.Errorf("expected exactly 1 call to the failing backend, got %d", got)
+	}
+	if got := atomic.LoadInt32(&goodCalls); got != 1 {
+		t.Errorf("expected exactly 1 retry against the healthy backend, got %d", got)
+	}
+}
+
+// TestRetryReplaysRequestBodyOnRetry verifies that a PUT carrying a body
+// still delivers that body in full to the backend it's retried against,
+// rather than arriving empty because the first attempt already drained it.
+func TestRetryReplaysRequestBodyOnRetry(t *testing.T) {
+	const payload = "important-payload"
+
+	var goodBody string
+
+	badBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer badBackend.Close()
+
+	goodBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("backend failed to read request body: %v", err)
+		}
+		goodBody = string(body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer goodBackend.Close()
+
+	setBackendHealth(badBackend.URL, true)
+	setBackendHealth(goodBackend.URL, true)
+
+	config := Config{
+		BackendURLs: []string{badBackend.URL, goodBackend.URL},
+		Retry:       RetryConfig{MaxAttempts: 2},
+	}
+
+	req, err := http.NewRequest(http.MethodPut, "http://localhost", strings.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "192.168.1.1:12345"
+
+	lb := NewLoadBalancer(config.BackendURLs)
+	rr := httptest.NewRecorder()
+	frontendHandler(lb, newLiveConfig(lb, config))(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected the retry to reach the healthy backend, got status %d", rr.Code)
+	}
+	if goodBody != payload {
+		t.Errorf("expected the retried backend to receive the full body %q, got %q", payload, goodBody)
+	}
+}
+
+// TestRetryDoesNotRetryNonIdempotentMethod verifies that a POST (not in the
+// idempotent method set) is never retried, even when MaxAttempts > 1, and
+// that the backend's real 5xx reaches the client.
+func TestRetryDoesNotRetryNonIdempotentMethod(t *testing.T) {
+	var calls int32
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer backend.Close()
+
+	setBackendHealth(backend.URL, true)
+
+	config := Config{
+		BackendURLs: []string{backend.URL},
+		Retry:       RetryConfig{MaxAttempts: 3},
+	}
+
+	req, err := http.NewRequest("POST", "http://localhost", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "192.168.1.1:12345"
+
+	lb := NewLoadBalancer(config.BackendURLs)
+	rr := httptest.NewRecorder()
+	frontendHandler(lb, newLiveConfig(lb, config))(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected the backend's real 5xx to reach the client, got %d", rr.Code)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("expected exactly 1 call for a non-idempotent method, got %d", got)
+	}
+}
+
+// TestRetryUnderIPHashTriesOtherBackend verifies that a retry against the
+// ip_hash strategy doesn't just hash straight back to the backend that
+// already failed it: the sticky pick must move on to another healthy
+// backend once the first attempt fails.
+func TestRetryUnderIPHashTriesOtherBackend(t *testing.T) {
+	var badCalls, goodCalls int32
+
+	badBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&badCalls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer badBackend.Close()
+
+	goodBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&goodCalls, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer goodBackend.Close()
+
+	setBackendHealth(badBackend.URL, true)
+	setBackendHealth(goodBackend.URL, true)
+
+	config := Config{
+		BackendURLs: []string{badBackend.URL, goodBackend.URL},
+		Strategy:    "ip_hash",
+		Retry:       RetryConfig{MaxAttempts: 2},
+	}
+
+	req, err := http.NewRequest("GET", "http://localhost", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "192.168.1.1:12345"
+
+	lb := NewLoadBalancer(config.BackendURLs)
+	rr := httptest.NewRecorder()
+	frontendHandler(lb, newLiveConfig(lb, config))(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected the retry to reach the healthy backend, got status %d", rr.Code)
+	}
+	if got := atomic.LoadInt32(&badCalls); got != 1 {
+		t.Errorf("expected exactly 1 call to the failing backend, got %d", got)
+	}
+	if got := atomic.LoadInt32(&goodCalls); got != 1 {
+		t.Errorf("expected ip_hash's retry to reach the other backend instead of repeating its sticky pick, got %d calls", got)
+	}
+}
+
+// TestRetryExhaustsAttemptsReturnsBadGateway verifies that once every retry
+// attempt fails with a connection error, the client gets a 502 and no more
+// than MaxAttempts attempts were made.
+func TestRetryExhaustsAttemptsReturnsBadGateway(t *testing.T) {
+	const unreachable = "http://127.0.0.1:1"
+
+	setBackendHealth(unreachable, true)
+
+	config := Config{
+		BackendURLs: []string{unreachable},
+		Retry:       RetryConfig{MaxAttempts: 2},
+	}
+
+	req, err := http.NewRequest("GET", "http://localhost", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "192.168.1.1:12345"
+
+	lb := NewLoadBalancer(config.BackendURLs)
+	rr := httptest.NewRecorder()
+	frontendHandler(lb, newLiveConfig(lb, config))(rr, req)
+
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("expected a 502 once all retry attempts failed, got %d", rr.Code)
+	}
+}